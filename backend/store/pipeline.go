@@ -3,36 +3,137 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 
 	"github.com/bytebase/bytebase/backend/common"
 )
 
+// PipelineStatus is the status of a pipeline in its lifecycle state machine.
+type PipelineStatus string
+
+const (
+	// PipelineStatusPending is the status for a pipeline that has not started running yet.
+	PipelineStatusPending PipelineStatus = "PENDING"
+	// PipelineStatusRunning is the status for a pipeline that is currently running.
+	PipelineStatusRunning PipelineStatus = "RUNNING"
+	// PipelineStatusSucceeded is the status for a pipeline that finished successfully.
+	PipelineStatusSucceeded PipelineStatus = "SUCCEEDED"
+	// PipelineStatusFailed is the status for a pipeline that finished with an error.
+	PipelineStatusFailed PipelineStatus = "FAILED"
+	// PipelineStatusCanceled is the status for a pipeline that was canceled before it finished.
+	PipelineStatusCanceled PipelineStatus = "CANCELED"
+	// PipelineStatusSkipped is the status for a pipeline that was skipped without running.
+	PipelineStatusSkipped PipelineStatus = "SKIPPED"
+)
+
+// PipelineOrderBy specifies the column ListPipelineV2 orders results by.
+type PipelineOrderBy string
+
+const (
+	// PipelineOrderByCreatedTs orders pipelines by creation time, newest first.
+	PipelineOrderByCreatedTs PipelineOrderBy = "created_ts"
+	// PipelineOrderByDisplayOrder orders pipelines by their curated display order.
+	PipelineOrderByDisplayOrder PipelineOrderBy = "display_order"
+)
+
+// ErrIllegalStateTransition is returned by UpdatePipelineStatusV2 when the pipeline's
+// current status no longer matches the expected "from" status, e.g. because a
+// concurrent caller already transitioned it.
+type ErrIllegalStateTransition struct {
+	ID   int
+	From PipelineStatus
+	To   PipelineStatus
+}
+
+// Error implements the error interface.
+func (e *ErrIllegalStateTransition) Error() string {
+	return fmt.Sprintf("illegal pipeline state transition for pipeline %d: %s -> %s", e.ID, e.From, e.To)
+}
+
 // PipelineMessage is the message for pipelines.
 type PipelineMessage struct {
 	ProjectID string
 	Name      string
 	Stages    []*StageMessage
 	// Output only.
-	ID         int
-	CreatorUID int
-	CreatedTs  int64
-	UpdaterUID int
-	UpdatedTs  int64
+	ID int
+	// Number is the pipeline's sequence number within its project, e.g. the
+	// "42" in project/xyz/pipelines/42. It is assigned once at creation time
+	// and never reused, independent of the global auto-increment ID.
+	Number       int64
+	Status       PipelineStatus
+	StartedTs    *int64
+	FinishedTs   *int64
+	Paused       bool
+	PausedReason string
+	DisplayOrder int
+	CreatorUID   int
+	CreatedTs    int64
+	UpdaterUID   int
+	UpdatedTs    int64
 }
 
 // PipelineFind is the API message for finding pipelines.
 type PipelineFind struct {
 	ID        *int
 	ProjectID *string
+	Number    *int64
+
+	// IDs and ProjectIDs resolve multiple pipelines in a single round trip, e.g.
+	// for BatchGetPipelineV2ByIDs.
+	IDs        []int
+	ProjectIDs []string
+
+	StatusIn       []PipelineStatus
+	FinishedBefore *int64
+	ExcludePaused  *bool
+
+	OrderBy *PipelineOrderBy
+
+	// PageToken is an opaque, base64-encoded keyset cursor, as returned by
+	// ListPipelineV2Page. ListPipelineV2Page ignores Offset; ListPipelineV2 applies
+	// both the cursor and Offset when both are set.
+	PageToken *string
 
+	// Limit and Offset implement offset pagination.
+	//
+	// Deprecated: offset pagination makes Postgres walk every skipped row, which
+	// degrades badly for projects with thousands of pipelines. Prefer
+	// ListPipelineV2Page with PageToken instead.
 	Limit  *int
 	Offset *int
 }
 
+// pipelinePageCursor is the decoded form of a PipelineFind.PageToken / the next page
+// token returned by ListPipelineV2Page.
+type pipelinePageCursor struct {
+	ID int `json:"id"`
+}
+
+func encodePipelinePageToken(id int) string {
+	// The cursor is a small trusted-format JSON blob; errors are not possible here.
+	b, _ := json.Marshal(pipelinePageCursor{ID: id})
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func decodePipelinePageToken(token string) (int, error) {
+	b, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return 0, &common.Error{Code: common.Invalid, Err: errors.Wrap(err, "invalid page token")}
+	}
+	var cursor pipelinePageCursor
+	if err := json.Unmarshal(b, &cursor); err != nil {
+		return 0, &common.Error{Code: common.Invalid, Err: errors.Wrap(err, "invalid page token")}
+	}
+	return cursor.ID, nil
+}
+
 // CreatePipelineV2 creates a pipeline.
 func (s *Store) CreatePipelineV2(ctx context.Context, create *PipelineMessage, creatorID int) (*PipelineMessage, error) {
 	tx, err := s.db.BeginTx(ctx, nil)
@@ -41,35 +142,52 @@ func (s *Store) CreatePipelineV2(ctx context.Context, create *PipelineMessage, c
 	}
 	defer tx.Rollback()
 
+	// Allocate the per-project pipeline number atomically by bumping
+	// project.next_pipeline_number in the same statement that inserts the
+	// row, so concurrent creations in the same project never race on the
+	// same number.
 	query := `
+		WITH updated_project AS (
+			UPDATE project
+			SET next_pipeline_number = next_pipeline_number + 1
+			WHERE project.resource_id = $1
+			RETURNING id, next_pipeline_number - 1 AS number
+		)
 		INSERT INTO pipeline (
 			project_id,
 			creator_id,
 			updater_id,
-			name
+			name,
+			number,
+			status
 		)
-		VALUES (
-			(SELECT project.id FROM project WHERE project.resource_id = $1),
+		SELECT
+			updated_project.id,
 			$2,
 			$3,
-			$4
-		)
-		RETURNING id, created_ts
+			$4,
+			updated_project.number,
+			$5
+		FROM updated_project
+		RETURNING id, created_ts, number
 	`
 	pipeline := &PipelineMessage{
 		ProjectID:  create.ProjectID,
 		CreatorUID: creatorID,
 		UpdaterUID: creatorID,
 		Name:       create.Name,
+		Status:     PipelineStatusPending,
 	}
 	if err := tx.QueryRowContext(ctx, query,
 		create.ProjectID,
 		creatorID,
 		creatorID,
 		create.Name,
+		pipeline.Status,
 	).Scan(
 		&pipeline.ID,
 		&pipeline.CreatedTs,
+		&pipeline.Number,
 	); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, common.FormatDBErrorEmptyRowWithQuery(query)
@@ -105,6 +223,274 @@ func (s *Store) GetPipelineV2ByID(ctx context.Context, id int) (*PipelineMessage
 	return pipeline, nil
 }
 
+// BatchGetPipelineV2ByIDs resolves many pipelines in a single round trip. It first
+// consults s.pipelineCache for hits, issues one query for the misses, and populates
+// the cache with the results, including negative entries for ids that turn out not
+// to exist. This mirrors the dataloader pattern and replaces the N+1 round trips that
+// calling GetPipelineV2ByID in a loop produces from the issue/rollout code paths.
+func (s *Store) BatchGetPipelineV2ByIDs(ctx context.Context, ids []int) (map[int]*PipelineMessage, error) {
+	result := make(map[int]*PipelineMessage)
+	var misses []int
+	for _, id := range ids {
+		if v, ok := s.pipelineCache.Get(id); ok {
+			if v != nil {
+				result[id] = v
+			}
+			continue
+		}
+		misses = append(misses, id)
+	}
+	if len(misses) == 0 {
+		return result, nil
+	}
+
+	pipelines, err := s.ListPipelineV2(ctx, &PipelineFind{IDs: misses})
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[int]bool)
+	for _, pipeline := range pipelines {
+		result[pipeline.ID] = pipeline
+		found[pipeline.ID] = true
+	}
+	for _, id := range misses {
+		if !found[id] {
+			s.pipelineCache.Add(id, nil)
+		}
+	}
+	return result, nil
+}
+
+// GetPipelineV2ByProjectAndNumber gets the pipeline by its project and per-project number.
+func (s *Store) GetPipelineV2ByProjectAndNumber(ctx context.Context, projectID string, number int64) (*PipelineMessage, error) {
+	pipelines, err := s.ListPipelineV2(ctx, &PipelineFind{ProjectID: &projectID, Number: &number})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pipelines) == 0 {
+		return nil, nil
+	} else if len(pipelines) > 1 {
+		return nil, &common.Error{Code: common.Conflict, Err: errors.Errorf("found %d pipelines, expect 1", len(pipelines))}
+	}
+	return pipelines[0], nil
+}
+
+// UpdatePipelineStatusV2 conditionally transitions a pipeline's status from `from` to
+// `to`. All status writes must go through this function so that transitions are
+// serialized by the database row itself instead of racing in application code: the
+// UPDATE only matches rows still in the `from` status, and zero rows affected means
+// someone else already transitioned it, in which case ErrIllegalStateTransition is
+// returned.
+func (s *Store) UpdatePipelineStatusV2(ctx context.Context, id int, from, to PipelineStatus, updaterID int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	set, args := []string{"status = $1", "updater_id = $2"}, []any{to, updaterID}
+	switch to {
+	case PipelineStatusRunning:
+		set, args = append(set, fmt.Sprintf("started_ts = $%d", len(args)+1)), append(args, time.Now().Unix())
+	case PipelineStatusSucceeded, PipelineStatusFailed, PipelineStatusCanceled, PipelineStatusSkipped:
+		set, args = append(set, fmt.Sprintf("finished_ts = $%d", len(args)+1)), append(args, time.Now().Unix())
+	}
+	args = append(args, id, from)
+	query := fmt.Sprintf(`
+		UPDATE pipeline
+		SET %s
+		WHERE id = $%d AND status = $%d
+	`, strings.Join(set, ", "), len(args)-1, len(args))
+
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if err := pipelineStatusTransitionResult(affected, id, from, to); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.pipelineCache.Remove(id)
+	return nil
+}
+
+// pipelineStatusTransitionResult turns the row count affected by the conditional
+// status UPDATE into the CAS outcome: zero rows means some other caller already
+// moved the pipeline out of `from`, so the transition is illegal for this caller.
+func pipelineStatusTransitionResult(affected int64, id int, from, to PipelineStatus) error {
+	if affected == 0 {
+		return &ErrIllegalStateTransition{ID: id, From: from, To: to}
+	}
+	return nil
+}
+
+// SetPipelinePausedV2 pauses or resumes a pipeline, recording why it was paused so
+// the scheduler can skip it without deleting it.
+func (s *Store) SetPipelinePausedV2(ctx context.Context, id int, paused bool, reason string, updaterID int) error {
+	query := `
+		UPDATE pipeline
+		SET paused = $1, paused_reason = $2, updater_id = $3
+		WHERE id = $4
+	`
+	if _, err := s.db.ExecContext(ctx, query, paused, reason, updaterID, id); err != nil {
+		return err
+	}
+	s.pipelineCache.Remove(id)
+	return nil
+}
+
+// validatePipelineOrder checks that orderedIDs is exactly the current set of
+// pipeline ids in the project (existing), with no duplicates or strangers.
+func validatePipelineOrder(existing map[int]bool, orderedIDs []int) error {
+	if len(existing) != len(orderedIDs) {
+		return errors.Errorf("orderedIDs has %d entries, project has %d pipelines", len(orderedIDs), len(existing))
+	}
+	seen := make(map[int]bool)
+	for _, id := range orderedIDs {
+		if !existing[id] {
+			return errors.Errorf("pipeline %d does not belong to the project", id)
+		}
+		if seen[id] {
+			return errors.Errorf("pipeline %d appears more than once in orderedIDs", id)
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// OrderPipelinesV2 rewrites the display order of every pipeline in a project in a
+// single transaction using a CASE expression, so no intermediate state exposes
+// duplicate orderings. orderedIDs must be exactly the current set of pipelines in
+// the project.
+func (s *Store) OrderPipelinesV2(ctx context.Context, projectID string, orderedIDs []int) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT pipeline.id
+		FROM pipeline
+		LEFT JOIN project ON pipeline.project_id = project.id
+		WHERE project.resource_id = $1
+	`, projectID)
+	if err != nil {
+		return err
+	}
+	existing := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	if err := validatePipelineOrder(existing, orderedIDs); err != nil {
+		return err
+	}
+	if len(orderedIDs) == 0 {
+		// Nothing to reorder; avoid building a CASE/IN clause with no entries,
+		// which is a syntax error in Postgres.
+		return nil
+	}
+
+	var caseStmts []string
+	var args []any
+	for i, id := range orderedIDs {
+		args = append(args, id, i)
+		caseStmts = append(caseStmts, fmt.Sprintf("WHEN $%d THEN $%d", len(args)-1, len(args)))
+	}
+	var wherePlaceholders []string
+	for _, id := range orderedIDs {
+		args = append(args, id)
+		wherePlaceholders = append(wherePlaceholders, fmt.Sprintf("$%d", len(args)))
+	}
+
+	query := fmt.Sprintf(`
+		UPDATE pipeline
+		SET display_order = CASE id %s END
+		WHERE id IN (%s)
+	`, strings.Join(caseStmts, " "), strings.Join(wherePlaceholders, ", "))
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	for _, id := range orderedIDs {
+		s.pipelineCache.Remove(id)
+	}
+	return nil
+}
+
+// ListPipelineV2Page lists pipelines using keyset (cursor) pagination, ordered by id
+// DESC, and returns the token to fetch the next page alongside the results. The
+// returned token is empty once there are no more pipelines. find.Limit must be set;
+// find.Offset is ignored in favor of find.PageToken.
+func (s *Store) ListPipelineV2Page(ctx context.Context, find *PipelineFind) ([]*PipelineMessage, string, error) {
+	if find.Limit == nil || *find.Limit <= 0 {
+		return nil, "", errors.Errorf("limit must be positive for keyset pagination")
+	}
+	if find.OrderBy != nil {
+		return nil, "", &common.Error{Code: common.Invalid, Err: errors.Errorf("keyset pagination only supports the default id DESC ordering, got OrderBy %q", *find.OrderBy)}
+	}
+	limit := *find.Limit
+
+	// Fetch one extra row so we can tell whether another page exists without a
+	// separate COUNT query.
+	fetchLimit := limit + 1
+	pageFind := *find
+	pageFind.Limit = &fetchLimit
+	pageFind.Offset = nil
+
+	pipelines, err := s.ListPipelineV2(ctx, &pageFind)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var nextPageToken string
+	if len(pipelines) > limit {
+		pipelines = pipelines[:limit]
+		nextPageToken = encodePipelinePageToken(pipelines[len(pipelines)-1].ID)
+	}
+	return pipelines, nextPageToken, nil
+}
+
+// appendInClause expands values into one placeholder per value and appends
+// "column IN ($N, $N+1, ...)" to where/args. database/sql's default parameter
+// converter doesn't accept a bare slice, so a multi-value filter can't bind as a
+// single arg to ANY($N); this mirrors the per-id placeholder expansion
+// OrderPipelinesV2 already uses for the same reason.
+func appendInClause[T any](where []string, args []any, column string, values []T) ([]string, []any) {
+	placeholders := make([]string, len(values))
+	for i, v := range values {
+		args = append(args, v)
+		placeholders[i] = fmt.Sprintf("$%d", len(args))
+	}
+	where = append(where, fmt.Sprintf("%s IN (%s)", column, strings.Join(placeholders, ", ")))
+	return where, args
+}
+
 // ListPipelineV2 lists pipelines.
 func (s *Store) ListPipelineV2(ctx context.Context, find *PipelineFind) ([]*PipelineMessage, error) {
 	where, args := []string{"TRUE"}, []any{}
@@ -114,6 +500,48 @@ func (s *Store) ListPipelineV2(ctx context.Context, find *PipelineFind) ([]*Pipe
 	if v := find.ProjectID; v != nil {
 		where, args = append(where, fmt.Sprintf("project.resource_id = $%d", len(args)+1)), append(args, *v)
 	}
+	if v := find.IDs; len(v) > 0 {
+		where, args = appendInClause(where, args, "pipeline.id", v)
+	}
+	if v := find.ProjectIDs; len(v) > 0 {
+		where, args = appendInClause(where, args, "project.resource_id", v)
+	}
+	if v := find.Number; v != nil {
+		where, args = append(where, fmt.Sprintf("pipeline.number = $%d", len(args)+1)), append(args, *v)
+	}
+	if v := find.StatusIn; len(v) > 0 {
+		where, args = appendInClause(where, args, "pipeline.status", v)
+	}
+	if v := find.FinishedBefore; v != nil {
+		where, args = append(where, fmt.Sprintf("pipeline.finished_ts < $%d", len(args)+1)), append(args, *v)
+	}
+	if v := find.ExcludePaused; v != nil && *v {
+		where = append(where, "NOT pipeline.paused")
+	}
+	if v := find.PageToken; v != nil && *v != "" {
+		// The cursor is only meaningful relative to the default id DESC
+		// ordering: id has no monotonic relationship to created_ts or
+		// display_order, so pairing it with another OrderBy would silently
+		// skip and/or duplicate rows across pages instead of erroring.
+		if find.OrderBy != nil {
+			return nil, &common.Error{Code: common.Invalid, Err: errors.Errorf("PageToken only supports the default id DESC ordering, got OrderBy %q", *find.OrderBy)}
+		}
+		id, err := decodePipelinePageToken(*v)
+		if err != nil {
+			return nil, err
+		}
+		where, args = append(where, fmt.Sprintf("pipeline.id < $%d", len(args)+1)), append(args, id)
+	}
+
+	orderBy := "pipeline.id DESC"
+	if v := find.OrderBy; v != nil {
+		switch *v {
+		case PipelineOrderByDisplayOrder:
+			orderBy = "pipeline.display_order ASC"
+		case PipelineOrderByCreatedTs:
+			orderBy = "pipeline.created_ts DESC"
+		}
+	}
 	query := fmt.Sprintf(`
 		SELECT
 			pipeline.id,
@@ -122,11 +550,18 @@ func (s *Store) ListPipelineV2(ctx context.Context, find *PipelineFind) ([]*Pipe
 			pipeline.updater_id,
 			pipeline.updated_ts,
 			project.resource_id,
-			pipeline.name
+			pipeline.name,
+			pipeline.number,
+			pipeline.status,
+			pipeline.started_ts,
+			pipeline.finished_ts,
+			pipeline.paused,
+			pipeline.paused_reason,
+			pipeline.display_order
 		FROM pipeline
 		LEFT JOIN project ON pipeline.project_id = project.id
 		WHERE %s
-		ORDER BY id DESC`, strings.Join(where, " AND "))
+		ORDER BY %s`, strings.Join(where, " AND "), orderBy)
 	if v := find.Limit; v != nil {
 		query += fmt.Sprintf(" LIMIT %d", *v)
 	}
@@ -149,6 +584,7 @@ func (s *Store) ListPipelineV2(ctx context.Context, find *PipelineFind) ([]*Pipe
 	var pipelines []*PipelineMessage
 	for rows.Next() {
 		var pipeline PipelineMessage
+		var startedTs, finishedTs sql.NullInt64
 		if err := rows.Scan(
 			&pipeline.ID,
 			&pipeline.CreatorUID,
@@ -157,9 +593,22 @@ func (s *Store) ListPipelineV2(ctx context.Context, find *PipelineFind) ([]*Pipe
 			&pipeline.UpdatedTs,
 			&pipeline.ProjectID,
 			&pipeline.Name,
+			&pipeline.Number,
+			&pipeline.Status,
+			&startedTs,
+			&finishedTs,
+			&pipeline.Paused,
+			&pipeline.PausedReason,
+			&pipeline.DisplayOrder,
 		); err != nil {
 			return nil, err
 		}
+		if startedTs.Valid {
+			pipeline.StartedTs = &startedTs.Int64
+		}
+		if finishedTs.Valid {
+			pipeline.FinishedTs = &finishedTs.Int64
+		}
 		pipelines = append(pipelines, &pipeline)
 	}
 	if err := rows.Err(); err != nil {
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/bytebase/bytebase/backend/common"
+)
+
+func TestPipelineStatusTransitionResult(t *testing.T) {
+	t.Run("succeeds when a row transitioned", func(t *testing.T) {
+		err := pipelineStatusTransitionResult(1, 1, PipelineStatusPending, PipelineStatusRunning)
+		require.NoError(t, err)
+	})
+
+	t.Run("rejects a losing CAS race", func(t *testing.T) {
+		err := pipelineStatusTransitionResult(0, 1, PipelineStatusPending, PipelineStatusRunning)
+		require.Error(t, err)
+
+		var transitionErr *ErrIllegalStateTransition
+		require.ErrorAs(t, err, &transitionErr)
+		require.Equal(t, 1, transitionErr.ID)
+		require.Equal(t, PipelineStatusPending, transitionErr.From)
+		require.Equal(t, PipelineStatusRunning, transitionErr.To)
+	})
+}
+
+func TestValidatePipelineOrder(t *testing.T) {
+	t.Run("empty project, empty order is valid", func(t *testing.T) {
+		require.NoError(t, validatePipelineOrder(map[int]bool{}, nil))
+	})
+
+	t.Run("exact match is valid", func(t *testing.T) {
+		existing := map[int]bool{1: true, 2: true, 3: true}
+		require.NoError(t, validatePipelineOrder(existing, []int{3, 1, 2}))
+	})
+
+	t.Run("rejects a mismatched count", func(t *testing.T) {
+		existing := map[int]bool{1: true, 2: true}
+		err := validatePipelineOrder(existing, []int{1})
+		require.Error(t, err)
+	})
+
+	t.Run("rejects a pipeline outside the project", func(t *testing.T) {
+		existing := map[int]bool{1: true, 2: true}
+		err := validatePipelineOrder(existing, []int{1, 99})
+		require.Error(t, err)
+	})
+
+	t.Run("rejects a duplicate id", func(t *testing.T) {
+		existing := map[int]bool{1: true, 2: true}
+		err := validatePipelineOrder(existing, []int{1, 1})
+		require.Error(t, err)
+	})
+}
+
+func TestPipelinePageToken(t *testing.T) {
+	t.Run("round trips through encode and decode", func(t *testing.T) {
+		token := encodePipelinePageToken(42)
+		id, err := decodePipelinePageToken(token)
+		require.NoError(t, err)
+		require.Equal(t, 42, id)
+	})
+
+	t.Run("rejects malformed base64 as a typed client error", func(t *testing.T) {
+		_, err := decodePipelinePageToken("not-valid-base64!!!")
+		require.Error(t, err)
+
+		var storeErr *common.Error
+		require.ErrorAs(t, err, &storeErr)
+		require.Equal(t, common.Invalid, storeErr.Code)
+	})
+
+	t.Run("rejects base64 that does not decode to the cursor JSON shape", func(t *testing.T) {
+		_, err := decodePipelinePageToken("bm90LWpzb24=") // base64("not-json")
+		require.Error(t, err)
+
+		var storeErr *common.Error
+		require.ErrorAs(t, err, &storeErr)
+		require.Equal(t, common.Invalid, storeErr.Code)
+	})
+}